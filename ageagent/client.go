@@ -0,0 +1,87 @@
+package ageagent
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Client talks to a running age-agent over a Unix-domain socket.
+type Client struct {
+	SocketPath string
+	Timeout    time.Duration
+}
+
+// NewClient returns a Client for the agent listening at socketPath.
+func NewClient(socketPath string) *Client {
+	return &Client{SocketPath: socketPath, Timeout: 2 * time.Second}
+}
+
+func (c *Client) roundTrip(req *Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", c.SocketPath, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.Timeout))
+	if err := WriteRequest(conn, req); err != nil {
+		return nil, err
+	}
+	resp, err := ReadResponse(conn)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Err != "" {
+		return nil, fmt.Errorf("ageagent: %s", resp.Err)
+	}
+	return resp, nil
+}
+
+// GetPassphrase returns the cached passphrase for fp, and false if there
+// was no cache hit (including if the agent is unreachable).
+func (c *Client) GetPassphrase(fp [32]byte) (string, bool) {
+	resp, err := c.roundTrip(&Request{Op: OpGetPass, Fingerprint: fp})
+	if err != nil || !resp.Found {
+		return "", false
+	}
+	return string(resp.Payload), true
+}
+
+// PutPassphrase caches pass under fp for ttl (or the agent's default if
+// ttl is zero). Errors, including an unreachable agent, are ignored by
+// callers that treat the agent as a best-effort optimization.
+func (c *Client) PutPassphrase(fp [32]byte, pass string, ttl time.Duration) error {
+	_, err := c.roundTrip(&Request{
+		Op:          OpPutPass,
+		Fingerprint: fp,
+		Payload:     []byte(pass),
+		TTLSeconds:  int(ttl / time.Second),
+	})
+	return err
+}
+
+// GetIdentity returns the cached decrypted identity file contents for fp.
+func (c *Client) GetIdentity(fp [32]byte) ([]byte, bool) {
+	resp, err := c.roundTrip(&Request{Op: OpGetIdentity, Fingerprint: fp})
+	if err != nil || !resp.Found {
+		return nil, false
+	}
+	return resp.Payload, true
+}
+
+// PutIdentity caches the decrypted identity file contents under fp.
+func (c *Client) PutIdentity(fp [32]byte, plaintext []byte, ttl time.Duration) error {
+	_, err := c.roundTrip(&Request{
+		Op:          OpPutIdentity,
+		Fingerprint: fp,
+		Payload:     plaintext,
+		TTLSeconds:  int(ttl / time.Second),
+	})
+	return err
+}
+
+// Flush asks the agent to zero and drop every cached entry.
+func (c *Client) Flush() error {
+	_, err := c.roundTrip(&Request{Op: OpFlush})
+	return err
+}
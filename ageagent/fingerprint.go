@@ -0,0 +1,20 @@
+package ageagent
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// FingerprintScryptSalt derives the cache key for a passphrase from the
+// salt of the scrypt stanza it unlocks, so the same passphrase cached for
+// one file isn't offered for another that happens to share a passphrase.
+func FingerprintScryptSalt(salt []byte) [32]byte {
+	return sha256.Sum256(append([]byte("age-agent scrypt salt v1\x00"), salt...))
+}
+
+// FingerprintIdentityFile derives the cache key for a decrypted identity
+// file from its path and modification time, so a stale cache entry isn't
+// served after the file on disk has changed.
+func FingerprintIdentityFile(path string, modTime int64) [32]byte {
+	return sha256.Sum256([]byte(fmt.Sprintf("age-agent identity file v1\x00%s\x00%d", path, modTime)))
+}
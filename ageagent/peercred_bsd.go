@@ -0,0 +1,36 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+package ageagent
+
+import (
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerIsOwner reports whether the process on the other end of uc is running
+// as the same uid as this process, using LOCAL_PEERCRED.
+func peerIsOwner(uc *net.UnixConn) (bool, error) {
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return false, err
+	}
+	var uid uint32
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		xu, err := unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+		if err != nil {
+			credErr = err
+			return
+		}
+		uid = xu.Uid
+	})
+	if err != nil {
+		return false, err
+	}
+	if credErr != nil {
+		return false, credErr
+	}
+	return uid == uint32(os.Getuid()), nil
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package ageagent
+
+import (
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerIsOwner reports whether the process on the other end of uc is running
+// as the same uid as this process, using SO_PEERCRED.
+func peerIsOwner(uc *net.UnixConn) (bool, error) {
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return false, err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return false, err
+	}
+	if credErr != nil {
+		return false, credErr
+	}
+	return cred.Uid == uint32(os.Getuid()), nil
+}
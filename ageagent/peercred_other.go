@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package ageagent
+
+import (
+	"errors"
+	"net"
+)
+
+// peerIsOwner is not implemented on this platform: the agent refuses every
+// connection rather than silently skip the ownership check.
+func peerIsOwner(uc *net.UnixConn) (bool, error) {
+	return false, errors.New("ageagent: peer credential checks are not supported on this platform")
+}
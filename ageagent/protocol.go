@@ -0,0 +1,176 @@
+// Copyright 2019 The age Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ageagent implements a gpg-agent-style local cache for the secrets
+// age needs to prompt a human for: scrypt passphrases and decrypted
+// identity files. A long-running agent process holds them in memory for a
+// limited time so that many age invocations against the same files only
+// prompt once.
+//
+// The agent is reached over a Unix-domain socket using a minimal
+// length-prefixed, op-and-fingerprint request/response protocol. Nothing is
+// ever written to disk, and entries are zeroed as soon as they expire.
+package ageagent
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Op identifies the operation carried by a Request.
+type Op byte
+
+const (
+	OpGetPass Op = iota + 1
+	OpPutPass
+	OpGetIdentity
+	OpPutIdentity
+	OpFlush
+)
+
+// DefaultTTLSeconds is how long the agent caches a secret when a Request
+// doesn't set TTLSeconds explicitly.
+const DefaultTTLSeconds = 600
+
+// maxPayload bounds a single request or response body, so a misbehaving
+// peer can't make the agent allocate without limit.
+const maxPayload = 1 << 20 // 1 MiB
+
+// Request is sent by a client to the agent. Fingerprint identifies the
+// secret being asked for or supplied: the SHA-256 of the scrypt stanza's
+// salt for passphrases, or of the identity file's path and mtime for
+// decrypted identity files. Payload carries the secret itself for the Put
+// operations, and TTLSeconds optionally overrides DefaultTTLSeconds.
+type Request struct {
+	Op          Op
+	Fingerprint [32]byte
+	Payload     []byte
+	TTLSeconds  int
+}
+
+// Response is sent by the agent in reply to a Request. Found is false for a
+// Get that misses, or for any operation that failed (in which case Err
+// holds a human-readable message).
+type Response struct {
+	Found   bool
+	Payload []byte
+	Err     string
+}
+
+// WriteRequest writes req to w as a length-prefixed frame.
+func WriteRequest(w io.Writer, req *Request) error {
+	if len(req.Payload) > maxPayload {
+		return errors.New("ageagent: payload too large")
+	}
+	buf := make([]byte, 0, 1+32+8+4+len(req.Payload))
+	buf = append(buf, byte(req.Op))
+	buf = append(buf, req.Fingerprint[:]...)
+	var ttl [8]byte
+	binary.BigEndian.PutUint64(ttl[:], uint64(req.TTLSeconds))
+	buf = append(buf, ttl[:]...)
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(req.Payload)))
+	buf = append(buf, n[:]...)
+	buf = append(buf, req.Payload...)
+	return writeFrame(w, buf)
+}
+
+// ReadRequest reads a single length-prefixed Request frame from r.
+func ReadRequest(r io.Reader) (*Request, error) {
+	frame, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) < 1+32+8+4 {
+		return nil, errors.New("ageagent: truncated request")
+	}
+	req := &Request{Op: Op(frame[0])}
+	copy(req.Fingerprint[:], frame[1:33])
+	req.TTLSeconds = int(binary.BigEndian.Uint64(frame[33:41]))
+	n := binary.BigEndian.Uint32(frame[41:45])
+	if int(n) != len(frame)-45 {
+		return nil, errors.New("ageagent: corrupt request length")
+	}
+	req.Payload = frame[45:]
+	return req, nil
+}
+
+// WriteResponse writes resp to w as a length-prefixed frame.
+func WriteResponse(w io.Writer, resp *Response) error {
+	if len(resp.Payload) > maxPayload {
+		return errors.New("ageagent: payload too large")
+	}
+	var found byte
+	if resp.Found {
+		found = 1
+	}
+	buf := make([]byte, 0, 1+4+len(resp.Err)+4+len(resp.Payload))
+	buf = append(buf, found)
+	var errLen [4]byte
+	binary.BigEndian.PutUint32(errLen[:], uint32(len(resp.Err)))
+	buf = append(buf, errLen[:]...)
+	buf = append(buf, resp.Err...)
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(resp.Payload)))
+	buf = append(buf, n[:]...)
+	buf = append(buf, resp.Payload...)
+	return writeFrame(w, buf)
+}
+
+// ReadResponse reads a single length-prefixed Response frame from r.
+func ReadResponse(r io.Reader) (*Response, error) {
+	frame, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) < 1+4 {
+		return nil, errors.New("ageagent: truncated response")
+	}
+	resp := &Response{Found: frame[0] == 1}
+	errLen := binary.BigEndian.Uint32(frame[1:5])
+	off := 5
+	if uint32(len(frame)-off) < errLen {
+		return nil, errors.New("ageagent: corrupt response error length")
+	}
+	resp.Err = string(frame[off : off+int(errLen)])
+	off += int(errLen)
+	if len(frame)-off < 4 {
+		return nil, errors.New("ageagent: truncated response payload length")
+	}
+	n := binary.BigEndian.Uint32(frame[off : off+4])
+	off += 4
+	if int(n) != len(frame)-off {
+		return nil, errors.New("ageagent: corrupt response payload length")
+	}
+	resp.Payload = frame[off:]
+	return resp, nil
+}
+
+func writeFrame(w io.Writer, body []byte) error {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(body)))
+	if _, err := w.Write(n[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if size > maxPayload {
+		return nil, fmt.Errorf("ageagent: frame of %d bytes exceeds limit", size)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
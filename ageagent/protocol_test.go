@@ -0,0 +1,89 @@
+package ageagent
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRequestRoundTrip(t *testing.T) {
+	want := &Request{
+		Op:         OpPutIdentity,
+		Payload:    []byte("super secret identity bytes"),
+		TTLSeconds: 300,
+	}
+	want.Fingerprint[0] = 0xAB
+	want.Fingerprint[31] = 0xCD
+
+	var buf bytes.Buffer
+	if err := WriteRequest(&buf, want); err != nil {
+		t.Fatalf("WriteRequest: %v", err)
+	}
+	got, err := ReadRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadRequest: %v", err)
+	}
+	if got.Op != want.Op || got.TTLSeconds != want.TTLSeconds ||
+		got.Fingerprint != want.Fingerprint || !bytes.Equal(got.Payload, want.Payload) {
+		t.Fatalf("round-trip mismatch: got %+v, want %+v", got, want)
+	}
+}
+
+func TestRequestRoundTripEmptyPayload(t *testing.T) {
+	want := &Request{Op: OpGetPass}
+	var buf bytes.Buffer
+	if err := WriteRequest(&buf, want); err != nil {
+		t.Fatalf("WriteRequest: %v", err)
+	}
+	got, err := ReadRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadRequest: %v", err)
+	}
+	if len(got.Payload) != 0 {
+		t.Fatalf("expected empty payload, got %q", got.Payload)
+	}
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	want := &Response{Found: true, Payload: []byte("hunter2")}
+	var buf bytes.Buffer
+	if err := WriteResponse(&buf, want); err != nil {
+		t.Fatalf("WriteResponse: %v", err)
+	}
+	got, err := ReadResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadResponse: %v", err)
+	}
+	if got.Found != want.Found || got.Err != want.Err || !bytes.Equal(got.Payload, want.Payload) {
+		t.Fatalf("round-trip mismatch: got %+v, want %+v", got, want)
+	}
+}
+
+func TestResponseRoundTripError(t *testing.T) {
+	want := &Response{Found: false, Err: "ageagent: unknown operation"}
+	var buf bytes.Buffer
+	if err := WriteResponse(&buf, want); err != nil {
+		t.Fatalf("WriteResponse: %v", err)
+	}
+	got, err := ReadResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadResponse: %v", err)
+	}
+	if got.Found != want.Found || got.Err != want.Err || len(got.Payload) != 0 {
+		t.Fatalf("round-trip mismatch: got %+v, want %+v", got, want)
+	}
+}
+
+func TestReadRequestRejectsOversizedFrame(t *testing.T) {
+	var buf bytes.Buffer
+	// A frame length header alone, well past maxPayload, with no body to match.
+	if err := writeFrame(&buf, make([]byte, 0)); err != nil {
+		t.Fatalf("writeFrame: %v", err)
+	}
+	buf.Reset()
+	var n [4]byte
+	n[0] = 0x7f // size = 0x7f000000, far beyond maxPayload
+	buf.Write(n[:])
+	if _, err := readFrame(&buf); err == nil {
+		t.Fatal("expected an error for a frame exceeding maxPayload, got nil")
+	}
+}
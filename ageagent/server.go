@@ -0,0 +1,180 @@
+package ageagent
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Server is a running age-agent: it listens on a Unix-domain socket and
+// answers GET/PUT requests for passphrases and decrypted identities out of
+// an in-memory, TTL-expiring cache. Nothing it holds is ever written to
+// disk, and entries are zeroed the moment they expire or are flushed.
+type Server struct {
+	DefaultTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[[32]byte]*cacheEntry
+}
+
+type cacheEntry struct {
+	payload []byte
+	timer   *time.Timer
+}
+
+// NewServer creates a Server with the given default TTL for entries that
+// don't specify their own.
+func NewServer(defaultTTL time.Duration) *Server {
+	if defaultTTL <= 0 {
+		defaultTTL = DefaultTTLSeconds * time.Second
+	}
+	return &Server{
+		DefaultTTL: defaultTTL,
+		entries:    make(map[[32]byte]*cacheEntry),
+	}
+}
+
+// Listen creates the Unix-domain socket at path, restricted to mode 0600,
+// removing any stale socket left over from a previous run first.
+func Listen(path string) (net.Listener, error) {
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Serve accepts connections from l until it's closed, handling each on its
+// own goroutine. A connection whose peer uid doesn't match the process
+// owner's is refused before any request is read.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errIsClosed(err) {
+				return nil
+			}
+			return err
+		}
+		uc, ok := conn.(*net.UnixConn)
+		if !ok {
+			conn.Close()
+			continue
+		}
+		if ok, err := peerIsOwner(uc); err != nil || !ok {
+			log.Printf("ageagent: refusing connection from non-owner peer: %v", err)
+			conn.Close()
+			continue
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		req, err := ReadRequest(conn)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("ageagent: reading request: %v", err)
+			}
+			return
+		}
+		resp := s.dispatch(req)
+		if err := WriteResponse(conn, resp); err != nil {
+			log.Printf("ageagent: writing response: %v", err)
+			return
+		}
+	}
+}
+
+func (s *Server) dispatch(req *Request) *Response {
+	switch req.Op {
+	case OpGetPass, OpGetIdentity:
+		if payload, ok := s.get(req.Fingerprint); ok {
+			return &Response{Found: true, Payload: payload}
+		}
+		return &Response{Found: false}
+	case OpPutPass, OpPutIdentity:
+		ttl := s.DefaultTTL
+		if req.TTLSeconds > 0 {
+			ttl = time.Duration(req.TTLSeconds) * time.Second
+		}
+		s.put(req.Fingerprint, req.Payload, ttl)
+		return &Response{Found: true}
+	case OpFlush:
+		s.flush()
+		return &Response{Found: true}
+	default:
+		return &Response{Found: false, Err: "ageagent: unknown operation"}
+	}
+}
+
+func (s *Server) get(fp [32]byte) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[fp]
+	if !ok {
+		return nil, false
+	}
+	cp := make([]byte, len(e.payload))
+	copy(cp, e.payload)
+	return cp, true
+}
+
+func (s *Server) put(fp [32]byte, payload []byte, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.entries[fp]; ok {
+		old.timer.Stop()
+		zero(old.payload)
+	}
+	cp := make([]byte, len(payload))
+	copy(cp, payload)
+	e := &cacheEntry{payload: cp}
+	e.timer = time.AfterFunc(ttl, func() { s.expire(fp, e) })
+	s.entries[fp] = e
+}
+
+// expire drops fp's entry, but only if it's still the entry whose timer
+// fired: a put() racing with an about-to-fire timer (old.timer.Stop()
+// returning false because the timer already fired, just not yet past this
+// lock) must not let the stale expire() reach in and zero the replacement
+// entry it has nothing to do with.
+func (s *Server) expire(fp [32]byte, e *cacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.entries[fp]; ok && cur == e {
+		zero(e.payload)
+		delete(s.entries, fp)
+	}
+}
+
+// flush immediately zeroes and drops every cached entry, for OpFlush.
+func (s *Server) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for fp, e := range s.entries {
+		e.timer.Stop()
+		zero(e.payload)
+		delete(s.entries, fp)
+	}
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func errIsClosed(err error) bool {
+	return err == net.ErrClosed
+}
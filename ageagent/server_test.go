@@ -0,0 +1,74 @@
+package ageagent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPutGet(t *testing.T) {
+	s := NewServer(time.Minute)
+	var fp [32]byte
+	fp[0] = 1
+	s.put(fp, []byte("hello"), time.Minute)
+
+	got, ok := s.get(fp)
+	if !ok || string(got) != "hello" {
+		t.Fatalf("get = %q, %v; want %q, true", got, ok, "hello")
+	}
+}
+
+func TestExpireDropsOwnEntry(t *testing.T) {
+	s := NewServer(time.Minute)
+	var fp [32]byte
+	fp[0] = 2
+	s.put(fp, []byte("secret"), time.Minute)
+
+	e := s.entries[fp]
+	s.expire(fp, e)
+
+	if _, ok := s.get(fp); ok {
+		t.Fatal("entry still present after expiring the current entry")
+	}
+}
+
+// TestExpireIgnoresSupersededEntry reproduces the race between a stale
+// timer's expire() firing and a fresh put() for the same fingerprint: the
+// stale expire() must not evict the entry that replaced it.
+func TestExpireIgnoresSupersededEntry(t *testing.T) {
+	s := NewServer(time.Minute)
+	var fp [32]byte
+	fp[0] = 3
+	s.put(fp, []byte("old"), time.Minute)
+	stale := s.entries[fp]
+
+	s.put(fp, []byte("new"), time.Minute)
+
+	// Simulate the old timer's callback finally acquiring the lock after
+	// being superseded by the put() above.
+	s.expire(fp, stale)
+
+	got, ok := s.get(fp)
+	if !ok {
+		t.Fatal("fresh entry was evicted by a stale expire() call")
+	}
+	if string(got) != "new" {
+		t.Fatalf("get = %q; want %q", got, "new")
+	}
+}
+
+func TestFlushClearsAllEntries(t *testing.T) {
+	s := NewServer(time.Minute)
+	var fp1, fp2 [32]byte
+	fp1[0], fp2[0] = 1, 2
+	s.put(fp1, []byte("a"), time.Minute)
+	s.put(fp2, []byte("b"), time.Minute)
+
+	s.flush()
+
+	if _, ok := s.get(fp1); ok {
+		t.Fatal("fp1 still present after flush")
+	}
+	if _, ok := s.get(fp2); ok {
+		t.Fatal("fp2 still present after flush")
+	}
+}
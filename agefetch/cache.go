@@ -0,0 +1,67 @@
+package agefetch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cacheTTL is how long a fetched key set is trusted without being
+// re-fetched, when -offline isn't forcing the cache to be used regardless
+// of age.
+const cacheTTL = 24 * time.Hour
+
+func cacheDir() (string, error) {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".cache")
+	}
+	return filepath.Join(dir, "age", "keys"), nil
+}
+
+func cachePath(kind, target string) (string, error) {
+	if strings.ContainsAny(target, "/\\") || target == ".." || target == "" {
+		return "", fmt.Errorf("%q is not a valid %s recipient", target, kind)
+	}
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, kind, target+".keys"), nil
+}
+
+// readCache returns the cached key set for kind/target, and whether it's
+// still within cacheTTL (stale entries are still returned, so -offline can
+// use them regardless of age).
+func readCache(kind, target string) (data []byte, fresh bool, err error) {
+	path, err := cachePath(kind, target)
+	if err != nil {
+		return nil, false, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, time.Since(fi.ModTime()) < cacheTTL, nil
+}
+
+func writeCache(kind, target string, data []byte) error {
+	path, err := cachePath(kind, target)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	return os.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,23 @@
+package agefetch
+
+import "testing"
+
+func TestCachePathRejectsPathTraversal(t *testing.T) {
+	for _, target := range []string{
+		"../../../../tmp/pwn",
+		"a/b",
+		`a\b`,
+		"..",
+		"",
+	} {
+		if _, err := cachePath("github", target); err == nil {
+			t.Errorf("cachePath(%q) = nil error, want one rejecting the path component", target)
+		}
+	}
+}
+
+func TestCachePathAcceptsOrdinaryTarget(t *testing.T) {
+	if _, err := cachePath("github", "octocat"); err != nil {
+		t.Fatalf("cachePath(%q): %v", "octocat", err)
+	}
+}
@@ -0,0 +1,117 @@
+package agefetch
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pinPath is ~/.config/age/pins: one "kind:target sha256hex" line per
+// recipient that's ever been resolved, recording the key set it resolved to
+// the first time (trust-on-first-use).
+func pinPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "age", "pins"), nil
+}
+
+func pinKey(kind, target string) string {
+	return kind + ":" + target
+}
+
+func readPins() (map[string]string, error) {
+	path, err := pinPath()
+	if err != nil {
+		return nil, err
+	}
+	pins := make(map[string]string)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return pins, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		pins[fields[0]] = fields[1]
+	}
+	return pins, scanner.Err()
+}
+
+func writePin(key string, sum string) error {
+	pins, err := readPins()
+	if err != nil {
+		return err
+	}
+	pins[key] = sum
+
+	path, err := pinPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	var buf strings.Builder
+	for k, v := range pins {
+		fmt.Fprintf(&buf, "%s %s\n", k, v)
+	}
+	return os.WriteFile(path, []byte(buf.String()), 0600)
+}
+
+func fingerprint(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkPin verifies data against the recorded pin for key, if any,
+// returning an error that hints at --update-pin on mismatch. If key has
+// never been pinned, it records data's fingerprint as the pin (TOFU) unless
+// noPin is set.
+func checkPin(kind, target string, data []byte, noPin bool) error {
+	if noPin {
+		return nil
+	}
+	key := pinKey(kind, target)
+	pins, err := readPins()
+	if err != nil {
+		return err
+	}
+	sum := fingerprint(data)
+	if pinned, ok := pins[key]; ok {
+		if pinned != sum {
+			return fmt.Errorf("the key set for %q changed since it was first pinned; "+
+				"run with --update-pin %s to accept the new keys, or --no-pin to skip pinning", target, key)
+		}
+		return nil
+	}
+	return writePin(key, sum)
+}
+
+// UpdatePin re-pins target's most recently fetched key set, bypassing the
+// mismatch check, for "age --update-pin kind:target".
+func UpdatePin(kind, target string, data []byte) error {
+	return writePin(pinKey(kind, target), fingerprint(data))
+}
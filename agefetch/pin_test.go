@@ -0,0 +1,97 @@
+package agefetch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func withPinDir(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+}
+
+func TestCheckPinTOFUOnFirstUse(t *testing.T) {
+	withPinDir(t)
+
+	data := []byte("ssh-ed25519 AAAA... first key\n")
+	if err := checkPin("github", "octocat", data, false); err != nil {
+		t.Fatalf("checkPin on first use: %v", err)
+	}
+
+	pins, err := readPins()
+	if err != nil {
+		t.Fatalf("readPins: %v", err)
+	}
+	if pins["github:octocat"] != fingerprint(data) {
+		t.Fatal("first use didn't record a pin")
+	}
+}
+
+func TestCheckPinAcceptsUnchangedKeys(t *testing.T) {
+	withPinDir(t)
+
+	data := []byte("ssh-ed25519 AAAA... key\n")
+	if err := checkPin("github", "octocat", data, false); err != nil {
+		t.Fatalf("first checkPin: %v", err)
+	}
+	if err := checkPin("github", "octocat", data, false); err != nil {
+		t.Fatalf("second checkPin with unchanged data: %v", err)
+	}
+}
+
+func TestCheckPinRejectsChangedKeys(t *testing.T) {
+	withPinDir(t)
+
+	if err := checkPin("github", "octocat", []byte("original key\n"), false); err != nil {
+		t.Fatalf("first checkPin: %v", err)
+	}
+	err := checkPin("github", "octocat", []byte("rotated key\n"), false)
+	if err == nil {
+		t.Fatal("expected an error for a changed key set, got nil")
+	}
+}
+
+func TestCheckPinNoPinSkipsEverything(t *testing.T) {
+	withPinDir(t)
+
+	if err := checkPin("github", "octocat", []byte("original key\n"), true); err != nil {
+		t.Fatalf("checkPin with noPin: %v", err)
+	}
+	// Nothing should have been recorded, so a later real checkPin call with
+	// different data for the same key must not see a mismatch.
+	if err := checkPin("github", "octocat", []byte("different key\n"), false); err != nil {
+		t.Fatalf("checkPin after a noPin call: %v", err)
+	}
+}
+
+func TestUpdatePinBypassesMismatch(t *testing.T) {
+	withPinDir(t)
+
+	if err := checkPin("github", "octocat", []byte("original key\n"), false); err != nil {
+		t.Fatalf("first checkPin: %v", err)
+	}
+	if err := UpdatePin("github", "octocat", []byte("rotated key\n")); err != nil {
+		t.Fatalf("UpdatePin: %v", err)
+	}
+	if err := checkPin("github", "octocat", []byte("rotated key\n"), false); err != nil {
+		t.Fatalf("checkPin after UpdatePin: %v", err)
+	}
+}
+
+func TestPinPathUnderXDGConfigHome(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	path, err := pinPath()
+	if err != nil {
+		t.Fatalf("pinPath: %v", err)
+	}
+	if want := filepath.Join(dir, "age", "pins"); path != want {
+		t.Fatalf("pinPath = %q, want %q", path, want)
+	}
+	if _, err := os.Stat(filepath.Dir(path)); err == nil {
+		t.Fatal("pinPath should not create the directory itself")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 The age Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package agefetch resolves "github:user", "gitlab:user" and "sshfp:host"
+// recipients by fetching the corresponding SSH public keys and converting
+// them to age recipients, with a local cache and trust-on-first-use
+// pinning so a compromised or rotated key set doesn't get silently
+// accepted on a later run. "sshfp:host" additionally verifies the
+// connecting host key against host's DNS SSHFP records when any are
+// published, rather than relying on the pin alone.
+package agefetch
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	age "github.com/srest2021/practical-crypto-project"
+	"github.com/srest2021/practical-crypto-project/agessh"
+)
+
+// Options controls how Resolve fetches and trusts keys.
+type Options struct {
+	// Offline forces the local cache to be used even if it's stale, and
+	// fails rather than reaching the network on a cache miss.
+	Offline bool
+	// NoPin skips recording or checking a trust-on-first-use pin for this
+	// resolution, for one-off use.
+	NoPin bool
+}
+
+// Resolve fetches the SSH public keys for a "kind:target" recipient — kind
+// is "github", "gitlab", or "sshfp" — and returns the matching age
+// recipients. Results are cached under $XDG_CACHE_HOME/age/keys and, unless
+// NoPin is set, checked against (or recorded to) ~/.config/age/pins.
+func Resolve(kind, target string, opts Options) ([]age.Recipient, error) {
+	data, err := fetchKeys(kind, target, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPin(kind, target, data, opts.NoPin); err != nil {
+		return nil, err
+	}
+	return parseSSHKeys(kind, target, data)
+}
+
+func fetchKeys(kind, target string, opts Options) ([]byte, error) {
+	cached, fresh, cacheErr := readCache(kind, target)
+	if opts.Offline {
+		if cacheErr != nil {
+			return nil, fmt.Errorf("--offline was passed but %s:%s isn't cached: %v", kind, target, cacheErr)
+		}
+		return cached, nil
+	}
+	if cacheErr == nil && fresh {
+		return cached, nil
+	}
+
+	data, err := fetchKeysOverNetwork(kind, target)
+	if err != nil {
+		if cacheErr == nil {
+			// The network fetch failed but we still have a (stale) cached
+			// copy: prefer it over a hard failure.
+			return cached, nil
+		}
+		return nil, err
+	}
+	if err := writeCache(kind, target, data); err != nil {
+		return nil, fmt.Errorf("failed to cache keys for %s:%s: %v", kind, target, err)
+	}
+	return data, nil
+}
+
+func fetchKeysOverNetwork(kind, target string) ([]byte, error) {
+	switch kind {
+	case "github":
+		return fetchHTTPKeys("https://github.com/" + target + ".keys")
+	case "gitlab":
+		return fetchHTTPKeys("https://gitlab.com/" + target + ".keys")
+	case "sshfp":
+		return fetchSSHHostKey(target)
+	default:
+		return nil, fmt.Errorf("unknown recipient resolver %q", kind)
+	}
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func fetchHTTPKeys(url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", url, err)
+	}
+	if len(bytesTrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("%s has no keys", url)
+	}
+	return data, nil
+}
+
+func bytesTrimSpace(b []byte) []byte {
+	return []byte(strings.TrimSpace(string(b)))
+}
+
+// RefreshPin re-fetches kind:target's keys over the network, caches them,
+// and re-pins them, bypassing any existing mismatched pin. It's the
+// implementation of "age --update-pin kind:target", for when a key
+// rotation is expected and legitimate.
+func RefreshPin(kind, target string) error {
+	data, err := fetchKeysOverNetwork(kind, target)
+	if err != nil {
+		return err
+	}
+	if err := writeCache(kind, target, data); err != nil {
+		return fmt.Errorf("failed to cache keys for %s:%s: %v", kind, target, err)
+	}
+	return UpdatePin(kind, target, data)
+}
+
+// parseSSHKeys converts each non-empty, non-comment line of data (an
+// authorized_keys-style SSH public key) into an age.Recipient.
+func parseSSHKeys(kind, target string, data []byte) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := agessh.ParseRecipient(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%s: malformed SSH key %q: %v", kind, target, line, err)
+		}
+		recipients = append(recipients, r)
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("%s:%s has no usable SSH keys", kind, target)
+	}
+	return recipients, nil
+}
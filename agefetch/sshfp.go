@@ -0,0 +1,333 @@
+package agefetch
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshfpRecord is one DNS SSHFP (RR type 44) record, as defined by RFC 4255:
+// the fingerprint of a host key, keyed by which key algorithm and digest
+// algorithm produced it.
+type sshfpRecord struct {
+	Algorithm   byte
+	FPType      byte
+	Fingerprint []byte
+}
+
+const (
+	sshfpTypeSHA1   = 1
+	sshfpTypeSHA256 = 2
+
+	sshfpAlgRSA     = 1
+	sshfpAlgDSA     = 2
+	sshfpAlgECDSA   = 3
+	sshfpAlgEd25519 = 4
+)
+
+// fetchSSHHostKey connects to host's SSH server and captures the host key it
+// presents during the handshake, returning it in authorized_keys form. If
+// host has DNS SSHFP records, the captured key must match one of them, the
+// same verification a real SSH client would do with VerifyHostKeyDNS
+// enabled. If no SSHFP records can be found (no usable resolver, or the
+// zone simply doesn't publish any), the key is instead trusted the same way
+// a freshly typed "ssh host" is: on first use, backstopped by checkPin so a
+// later change to it is caught.
+func fetchSSHHostKey(host string) ([]byte, error) {
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(host, "22")
+	}
+
+	records, recErr := lookupSSHFP(host)
+
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: "age-sshfp-probe",
+		Auth: []ssh.AuthMethod{},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			// Reject the connection itself: we only wanted the host key,
+			// not an authenticated session, and returning an error here
+			// avoids ssh.Dial attempting to authenticate.
+			return fmt.Errorf("age-sshfp: host key captured")
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	// ssh.Dial always returns an error here, by design of HostKeyCallback
+	// above; we only care whether it got far enough to see the host key.
+	_, _ = ssh.Dial("tcp", addr, config)
+	if hostKey == nil {
+		return nil, fmt.Errorf("failed to retrieve the SSH host key for %q", host)
+	}
+
+	if len(records) > 0 && !sshfpMatches(records, hostKey) {
+		return nil, fmt.Errorf("the SSH host key presented by %q doesn't match its DNS SSHFP record", host)
+	}
+	if len(records) == 0 && recErr != nil {
+		// Couldn't do better than TOFU: no resolver, or the lookup itself
+		// failed. Fall through silently, same as before SSHFP support
+		// existed; checkPin is still the backstop against a later change.
+		_ = recErr
+	}
+
+	return ssh.MarshalAuthorizedKey(hostKey), nil
+}
+
+// sshfpMatches reports whether key's fingerprint matches at least one of
+// records, for the matching key algorithm.
+func sshfpMatches(records []sshfpRecord, key ssh.PublicKey) bool {
+	alg := sshAlgorithmNumber(key)
+	raw := key.Marshal()
+	sum1 := sha1.Sum(raw)
+	sum256 := sha256.Sum256(raw)
+	for _, r := range records {
+		if alg != 0 && r.Algorithm != alg {
+			continue
+		}
+		switch r.FPType {
+		case sshfpTypeSHA1:
+			if bytes.Equal(r.Fingerprint, sum1[:]) {
+				return true
+			}
+		case sshfpTypeSHA256:
+			if bytes.Equal(r.Fingerprint, sum256[:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sshAlgorithmNumber(key ssh.PublicKey) byte {
+	switch {
+	case strings.HasPrefix(key.Type(), "ssh-rsa"):
+		return sshfpAlgRSA
+	case strings.HasPrefix(key.Type(), "ssh-dss"):
+		return sshfpAlgDSA
+	case strings.HasPrefix(key.Type(), "ecdsa-sha2-"):
+		return sshfpAlgECDSA
+	case strings.HasPrefix(key.Type(), "ssh-ed25519"):
+		return sshfpAlgEd25519
+	default:
+		return 0
+	}
+}
+
+// lookupSSHFP queries the system's configured nameservers for host's SSHFP
+// (RR type 44) records, by hand-rolling the minimal DNS wire format needed
+// rather than pulling in a full resolver. The standard library's
+// net.Resolver has no way to ask for an arbitrary RR type, so this sends
+// and parses the query itself over a plain UDP socket.
+func lookupSSHFP(host string) ([]sshfpRecord, error) {
+	servers, err := systemNameservers()
+	if err != nil {
+		return nil, err
+	}
+
+	query, id, err := buildSSHFPQuery(host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, server := range servers {
+		records, err := queryOne(server, query, id)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return records, nil
+	}
+	return nil, fmt.Errorf("no nameserver answered the SSHFP query for %q: %v", host, lastErr)
+}
+
+func queryOne(server string, query []byte, id uint16) ([]sshfpRecord, error) {
+	conn, err := net.Dial("udp", net.JoinHostPort(server, "53"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return parseSSHFPResponse(buf[:n], id)
+}
+
+// systemNameservers reads the "nameserver" lines of /etc/resolv.conf. This
+// is Unix-specific, in keeping with the rest of age's reliance on POSIX
+// conventions (Unix-domain sockets, XDG directories); on platforms without
+// it, lookupSSHFP simply fails and fetchSSHHostKey falls back to TOFU.
+func systemNameservers() ([]string, error) {
+	data, err := os.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "nameserver") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			servers = append(servers, fields[1])
+		}
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("no nameservers configured in /etc/resolv.conf")
+	}
+	return servers, nil
+}
+
+// buildSSHFPQuery builds a DNS query message asking for host's SSHFP
+// records, along with the transaction ID it was given so the response can
+// be matched to it.
+func buildSSHFPQuery(host string) ([]byte, uint16, error) {
+	name, err := encodeDNSName(host)
+	if err != nil {
+		return nil, 0, err
+	}
+	id, err := randomTransactionID()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to generate a DNS transaction ID: %v", err)
+	}
+
+	var buf bytes.Buffer
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint16(header[0:2], id)
+	binary.BigEndian.PutUint16(header[2:4], 0x0100) // RD (recursion desired)
+	binary.BigEndian.PutUint16(header[4:6], 1)      // QDCOUNT
+	buf.Write(header)
+
+	buf.Write(name)
+	var qtype, qclass [2]byte
+	binary.BigEndian.PutUint16(qtype[:], 44) // SSHFP
+	binary.BigEndian.PutUint16(qclass[:], 1) // IN
+	buf.Write(qtype[:])
+	buf.Write(qclass[:])
+
+	return buf.Bytes(), id, nil
+}
+
+// randomTransactionID generates a DNS transaction ID with crypto/rand: it's
+// the only defense against an off-path attacker's spoofed SSHFP response,
+// so it needs to be unpredictable, not just well-distributed.
+func randomTransactionID() (uint16, error) {
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b[:]), nil
+}
+
+func encodeDNSName(host string) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid DNS label in %q", host)
+		}
+		buf.WriteByte(byte(len(label)))
+		buf.WriteString(label)
+	}
+	buf.WriteByte(0)
+	return buf.Bytes(), nil
+}
+
+// parseSSHFPResponse extracts the SSHFP records from a DNS response message
+// whose transaction ID matches id.
+func parseSSHFPResponse(msg []byte, id uint16) ([]sshfpRecord, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("malformed DNS response: too short")
+	}
+	if binary.BigEndian.Uint16(msg[0:2]) != id {
+		return nil, errors.New("malformed DNS response: transaction ID mismatch")
+	}
+	rcode := msg[3] & 0x0F
+	if rcode != 0 {
+		return nil, fmt.Errorf("DNS query failed with RCODE %d", rcode)
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:6]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:8]))
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		next, err := skipDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4 // QTYPE + QCLASS
+	}
+
+	var records []sshfpRecord
+	for i := 0; i < ancount; i++ {
+		next, err := skipDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next
+		if off+10 > len(msg) {
+			return nil, errors.New("malformed DNS response: truncated answer")
+		}
+		rrtype := binary.BigEndian.Uint16(msg[off : off+2])
+		rdlength := int(binary.BigEndian.Uint16(msg[off+8 : off+10]))
+		off += 10
+		if off+rdlength > len(msg) {
+			return nil, errors.New("malformed DNS response: truncated rdata")
+		}
+		rdata := msg[off : off+rdlength]
+		off += rdlength
+
+		if rrtype == 44 && len(rdata) >= 2 {
+			fp := make([]byte, len(rdata)-2)
+			copy(fp, rdata[2:])
+			records = append(records, sshfpRecord{
+				Algorithm:   rdata[0],
+				FPType:      rdata[1],
+				Fingerprint: fp,
+			})
+		}
+	}
+	return records, nil
+}
+
+// skipDNSName advances past a (possibly compressed) DNS name starting at
+// off, returning the offset immediately after it.
+func skipDNSName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errors.New("malformed DNS response: name runs past end of message")
+		}
+		b := msg[off]
+		switch {
+		case b == 0:
+			return off + 1, nil
+		case b&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return 0, errors.New("malformed DNS response: truncated name pointer")
+			}
+			return off + 2, nil
+		default:
+			off += 1 + int(b)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 The age Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package agegroup lets a set of age.Recipient values be addressed and
+// wrapped to as a single named group, so recipient group files can expand
+// "@name" references (including to other groups) into the union of their
+// members' stanzas.
+package agegroup
+
+import (
+	"fmt"
+
+	age "github.com/srest2021/practical-crypto-project"
+)
+
+// GroupRecipient implements age.Recipient by wrapping to the union of every
+// member's stanzas, in order. It doesn't deduplicate members itself: a real
+// recipient's Wrap produces a fresh ephemeral key and ciphertext on every
+// call, so two Wrap calls for the same public key never produce identical
+// stanzas — that can't be used to recognize the same key listed in two
+// overlapping groups after the fact. Callers that need that (such as
+// cmd/age's group-file support, where "@all = @ops, ..." can repeat a key
+// already in @ops) must dedup members by a stable identity, e.g. the
+// recipient string, before constructing a GroupRecipient.
+type GroupRecipient struct {
+	Name    string
+	Members []age.Recipient
+}
+
+// New returns a GroupRecipient named name wrapping to every stanza produced
+// by members. Members can themselves be *GroupRecipient, which is how
+// nested group references ("@all = @ops, ...") are represented.
+func New(name string, members ...age.Recipient) *GroupRecipient {
+	return &GroupRecipient{Name: name, Members: members}
+}
+
+func (g *GroupRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	var stanzas []*age.Stanza
+	for _, m := range g.Members {
+		ss, err := m.Wrap(fileKey)
+		if err != nil {
+			return nil, fmt.Errorf("group %q: %v", g.Name, err)
+		}
+		stanzas = append(stanzas, ss...)
+	}
+	return stanzas, nil
+}
@@ -0,0 +1,85 @@
+package agegroup
+
+import (
+	"errors"
+	"testing"
+
+	age "github.com/srest2021/practical-crypto-project"
+)
+
+// stanzaRecipient is a minimal age.Recipient that always wraps to a fixed
+// set of stanzas, for exercising GroupRecipient.Wrap without a real
+// X25519/SSH recipient.
+type stanzaRecipient struct {
+	stanzas []*age.Stanza
+	err     error
+}
+
+func (r *stanzaRecipient) Wrap([]byte) ([]*age.Stanza, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.stanzas, nil
+}
+
+func stanza(typ string, body string, args ...string) *age.Stanza {
+	return &age.Stanza{Type: typ, Args: args, Body: []byte(body)}
+}
+
+func TestGroupRecipientWrapUnion(t *testing.T) {
+	a := &stanzaRecipient{stanzas: []*age.Stanza{stanza("X25519", "bodyA", "argA")}}
+	b := &stanzaRecipient{stanzas: []*age.Stanza{stanza("X25519", "bodyB", "argB")}}
+
+	g := New("ops", a, b)
+	stanzas, err := g.Wrap([]byte("file key"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	if len(stanzas) != 2 {
+		t.Fatalf("got %d stanzas, want 2", len(stanzas))
+	}
+}
+
+// TestGroupRecipientWrapDoesNotDedupByStanza documents that Wrap doesn't
+// dedup its members: a real recipient's Wrap output is randomized per call
+// (a fresh ephemeral key and ciphertext each time), so two members that
+// happen to produce byte-identical stanzas here is not something Wrap can
+// tell apart from two members that are actually the same key — dedup has
+// to happen earlier, by recipient identity, before members ever reach
+// GroupRecipient. See cmd/age's flattenGroup for where that happens.
+func TestGroupRecipientWrapDoesNotDedupByStanza(t *testing.T) {
+	shared := stanza("X25519", "same body", "same arg")
+	a := &stanzaRecipient{stanzas: []*age.Stanza{shared}}
+	b := &stanzaRecipient{stanzas: []*age.Stanza{stanza("X25519", "same body", "same arg")}}
+
+	g := New("all", a, b)
+	stanzas, err := g.Wrap([]byte("file key"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	if len(stanzas) != 2 {
+		t.Fatalf("got %d stanzas, want 2 (Wrap must not silently dedup)", len(stanzas))
+	}
+}
+
+func TestGroupRecipientWrapNested(t *testing.T) {
+	inner := New("ops", &stanzaRecipient{stanzas: []*age.Stanza{stanza("X25519", "inner")}})
+	outer := New("all", inner, &stanzaRecipient{stanzas: []*age.Stanza{stanza("X25519", "outer")}})
+
+	stanzas, err := outer.Wrap([]byte("file key"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	if len(stanzas) != 2 {
+		t.Fatalf("got %d stanzas, want 2", len(stanzas))
+	}
+}
+
+func TestGroupRecipientWrapPropagatesMemberError(t *testing.T) {
+	wantErr := errors.New("boom")
+	g := New("broken", &stanzaRecipient{err: wantErr})
+
+	if _, err := g.Wrap([]byte("file key")); err == nil {
+		t.Fatal("expected an error from a failing member, got nil")
+	}
+}
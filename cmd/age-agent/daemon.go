@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// daemonize re-execs the current binary without --daemonize in the
+// background, with its standard streams closed, and returns without waiting
+// for it. The child stays in the same session and process group, so it is
+// not detached from the controlling terminal. It's a deliberately simple
+// double-fork substitute: good enough for a local cache daemon, not a
+// general-purpose service manager.
+func daemonize() {
+	args := make([]string, 0, len(os.Args))
+	for _, a := range os.Args[1:] {
+		if a != "-daemonize" && a != "--daemonize" {
+			args = append(args, a)
+		}
+	}
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Stdin = nil
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "age-agent: failed to start in background: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "age-agent: started in the background, pid %d\n", cmd.Process.Pid)
+}
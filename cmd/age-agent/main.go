@@ -0,0 +1,65 @@
+// Copyright 2019 The age Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// age-agent is a long-running local process that caches decrypted scrypt
+// passphrases and decrypted identity files for age, so repeated "age -d"
+// invocations against many files don't have to re-prompt a human. It never
+// persists anything to disk, and every cached entry is zeroed when its TTL
+// expires.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/srest2021/practical-crypto-project/ageagent"
+)
+
+func main() {
+	var (
+		socketFlag string
+		ttlFlag    int
+		daemonFlag bool
+	)
+	flag.StringVar(&socketFlag, "socket", defaultSocketPath(), "Unix-domain socket `PATH` to listen on")
+	flag.IntVar(&ttlFlag, "ttl", ageagent.DefaultTTLSeconds, "default cache lifetime in `SECONDS`")
+	flag.BoolVar(&daemonFlag, "daemonize", false, "detach and run in the background")
+	flag.Parse()
+
+	if daemonFlag {
+		daemonize()
+		return
+	}
+
+	l, err := ageagent.Listen(socketFlag)
+	if err != nil {
+		log.Fatalf("age-agent: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "age-agent: listening on %s (set AGE_AGENT_SOCK to use it)\n", socketFlag)
+
+	s := ageagent.NewServer(time.Duration(ttlFlag) * time.Second)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		l.Close()
+	}()
+
+	if err := s.Serve(l); err != nil {
+		log.Fatalf("age-agent: %v", err)
+	}
+}
+
+func defaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir + "/age-agent.sock"
+	}
+	return fmt.Sprintf("%s/age-agent-%d.sock", os.TempDir(), os.Getuid())
+}
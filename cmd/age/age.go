@@ -16,6 +16,8 @@ import (
 	"strings"
 
 	age "github.com/srest2021/practical-crypto-project"
+	"github.com/srest2021/practical-crypto-project/ageagent"
+	"github.com/srest2021/practical-crypto-project/agefetch"
 	"github.com/srest2021/practical-crypto-project/agessh"
 	"github.com/srest2021/practical-crypto-project/armor"
 	"github.com/srest2021/practical-crypto-project/plugin"
@@ -35,17 +37,35 @@ Options:
     -p, --passphrase            Encrypt with a passphrase.
     -r, --recipient RECIPIENT   Encrypt to the specified RECIPIENT. Can be repeated.
     -R, --recipients-file PATH  Encrypt to recipients listed at PATH. Can be repeated.
+    -g, --group NAME            Select which group to expand from a -R file
+                                 that defines more than one.
     -i, --identity PATH         Use the identity file at PATH. Can be repeated.
+    --no-agent                  Don't use a running age-agent for cached
+                                 passphrases and identities.
+    --offline                   Resolve github:/gitlab:/sshfp: recipients
+                                 from the local cache only; fail rather than
+                                 reach the network.
+    --no-pin                    Don't check or record a trust-on-first-use
+                                 pin for github:/gitlab:/sshfp: recipients.
+    --update-pin KIND:TARGET    Re-fetch and re-pin KIND:TARGET (e.g.
+                                 github:octocat), then exit.
 
 INPUT defaults to standard input, and OUTPUT defaults to standard output.
 If OUTPUT exists, it will be overwritten.
 
 RECIPIENT can be an age public key generated by age-keygen ("age1...")
-or an SSH public key ("ssh-ed25519 AAAA...", "ssh-rsa AAAA...").
+or an SSH public key ("ssh-ed25519 AAAA...", "ssh-rsa AAAA..."). It can
+also be "github:user", "gitlab:user", or "sshfp:host", each resolved to
+the corresponding SSH keys over the network (or the local cache with
+--offline) and pinned against future changes; see --no-pin and
+--update-pin.
 
 Recipient files contain one or more recipients, one per line. Empty lines
 and lines starting with "#" are ignored as comments. "-" may be used to
-read recipients from standard input.
+read recipients from standard input. A recipient file may instead, or in
+addition, define named groups as "@name = recipient, recipient, ...",
+where a member can reference another group as "@othername"; use -g/--group
+to pick which group a multi-group file should expand.
 
 Identity files contain one or more secret keys ("AGE-SECRET-KEY-1..."),
 one per line, or an SSH key. Empty lines and lines starting with "#" are
@@ -53,6 +73,14 @@ ignored as comments. Passphrase encrypted age files can be used as
 identity files. Multiple key files can be provided, and any unused ones
 will be ignored. "-" may be used to read identities from standard input.
 
+-p/--passphrase can be combined with -r/-R/-i: the output carries a
+recipient stanza for each, and can be decrypted with either a matching
+identity or the passphrase.
+
+If AGE_AGENT_SOCK points at a running age-agent, age consults it before
+prompting for a passphrase or an encrypted identity file's passphrase, and
+feeds it back what it prompted for. Pass --no-agent to always prompt.
+
 When --encrypt is specified explicitly, -i can also be used to encrypt to an
 identity file symmetrically, instead or in addition to normal recipients.
 
@@ -71,6 +99,34 @@ var Version string
 // file is read from stdin. It's a singleton like os.Stdin.
 var stdinInUse bool
 
+// noAgentFlag disables lookups against a running age-agent (see --no-agent),
+// for scripts that would rather fail than block on a socket that might not
+// exist.
+var noAgentFlag bool
+
+// ageAgentClient returns a client for the age-agent pointed to by
+// AGE_AGENT_SOCK, or nil if --no-agent was passed or the variable isn't
+// set. Every caller must treat a nil client, and any error from it, as a
+// cache miss: the agent is a best-effort optimization, never a dependency.
+func ageAgentClient() *ageagent.Client {
+	if noAgentFlag {
+		return nil
+	}
+	sock := os.Getenv("AGE_AGENT_SOCK")
+	if sock == "" {
+		return nil
+	}
+	return ageagent.NewClient(sock)
+}
+
+// offlineFlag and noPinFlag back --offline and --no-pin, which control how
+// github:/gitlab:/sshfp: recipients are resolved by agefetch.
+var offlineFlag, noPinFlag bool
+
+func agefetchOptions() agefetch.Options {
+	return agefetch.Options{Offline: offlineFlag, NoPin: noPinFlag}
+}
+
 type multiFlag []string
 
 func (f *multiFlag) String() string { return fmt.Sprint(*f) }
@@ -110,6 +166,8 @@ func main() {
 		outFlag                          string
 		decryptFlag, encryptFlag         bool
 		passFlag, versionFlag, armorFlag bool
+		groupFlag                        string
+		updatePinFlag                    string
 		recipientFlags                   multiFlag
 		recipientsFileFlags              multiFlag
 		identityFlags                    identityFlags
@@ -133,8 +191,26 @@ func main() {
 	flag.Func("i", "identity (can be repeated)", identityFlags.addIdentityFlag)
 	flag.Func("identity", "identity (can be repeated)", identityFlags.addIdentityFlag)
 	flag.Func("j", "data-less plugin (can be repeated)", identityFlags.addPluginFlag)
+	flag.BoolVar(&noAgentFlag, "no-agent", false, "don't use a running age-agent for cached passphrases and identities")
+	flag.StringVar(&groupFlag, "g", "", "group `NAME` to expand from a -R file that defines more than one")
+	flag.StringVar(&groupFlag, "group", "", "group `NAME` to expand from a -R file that defines more than one")
+	flag.BoolVar(&offlineFlag, "offline", false, "resolve github:/gitlab:/sshfp: recipients from the cache only")
+	flag.BoolVar(&noPinFlag, "no-pin", false, "don't check or record a trust-on-first-use pin for resolved recipients")
+	flag.StringVar(&updatePinFlag, "update-pin", "", "re-fetch and re-pin `KIND:TARGET` (e.g. github:octocat), then exit")
 	flag.Parse()
 
+	if updatePinFlag != "" {
+		kind, target, ok := strings.Cut(updatePinFlag, ":")
+		if !ok {
+			errorf("--update-pin value must be KIND:TARGET, like github:octocat")
+		}
+		if err := agefetch.RefreshPin(kind, target); err != nil {
+			errorf("%v", err)
+		}
+		fmt.Printf("updated pin for %s\n", updatePinFlag)
+		return
+	}
+
 	if versionFlag {
 		if Version != "" {
 			fmt.Println(Version)
@@ -203,7 +279,13 @@ func main() {
 			errorWithHint("-R/--recipients-file can't be used with -d/--decrypt",
 				"did you mean to use -i/--identity to specify a private key?")
 		}
+		if groupFlag != "" {
+			errorf("-g/--group can't be used with -d/--decrypt")
+		}
 	default: // encrypt
+		if groupFlag != "" && len(recipientsFileFlags) == 0 {
+			errorf("-g/--group requires -R/--recipients-file")
+		}
 		if len(identityFlags) > 0 && !encryptFlag {
 			errorWithHint("-i/--identity and -j can't be used in encryption mode unless symmetric encryption is explicitly selected with -e/--encrypt",
 				"did you forget to specify -d/--decrypt?")
@@ -212,15 +294,10 @@ func main() {
 			errorWithHint("missing recipients",
 				"did you forget to specify -r/--recipient, -R/--recipients-file or -p/--passphrase?")
 		}
-		if len(recipientFlags) > 0 && passFlag {
-			errorf("-p/--passphrase can't be combined with -r/--recipient")
-		}
-		if len(recipientsFileFlags) > 0 && passFlag {
-			errorf("-p/--passphrase can't be combined with -R/--recipients-file")
-		}
-		if len(identityFlags) > 0 && passFlag {
-			errorf("-p/--passphrase can't be combined with -i/--identity and -j")
-		}
+		// -p/--passphrase can be freely combined with -r/-R/-i: the output file
+		// ends up with one scrypt stanza in addition to the other recipient
+		// stanzas, and can be decrypted with either the passphrase or a
+		// matching identity.
 	}
 
 	var in io.Reader = os.Stdin
@@ -279,10 +356,8 @@ func main() {
 		decryptPass(in, out)
 	case decryptFlag:
 		decryptNotPass(identityFlags, in, out)
-	case passFlag:
-		encryptPass(in, out, armorFlag)
 	default:
-		encryptNotPass(recipientFlags, recipientsFileFlags, identityFlags, in, out, armorFlag)
+		encrypt(recipientFlags, recipientsFileFlags, identityFlags, passFlag, in, out, armorFlag, groupFlag)
 	}
 }
 
@@ -314,22 +389,23 @@ func passphrasePromptForEncryption() (string, error) {
 	return p, nil
 }
 
-func encryptNotPass(recs, files []string, identities identityFlags, in io.Reader, out io.Writer, armor bool) {
+// encrypt gathers every recipient requested on the command line — including,
+// when pass is set, a freshly prompted-for age.ScryptRecipient — into a
+// single list of stanzas and writes the encrypted output. Combining -p with
+// -r/-R/-i is allowed: the age format supports any number of recipient
+// stanzas per file, so the result can be opened with either a matching
+// identity or the passphrase.
+func encrypt(recs, files []string, identities identityFlags, pass bool, in io.Reader, out io.Writer, armor bool, group string) {
 	var recipients []age.Recipient
 	for _, arg := range recs {
-		r, err := parseRecipient(arg)
-		if err, ok := err.(gitHubRecipientError); ok {
-			errorWithHint(err.Error(), "instead, use recipient files like",
-				"    curl -O https://github.com/"+err.username+".keys",
-				"    age -R "+err.username+".keys")
-		}
+		rs, err := parseRecipient(arg)
 		if err != nil {
 			errorf("%v", err)
 		}
-		recipients = append(recipients, r)
+		recipients = append(recipients, rs...)
 	}
 	for _, name := range files {
-		recs, err := parseRecipientsFile(name)
+		recs, err := parseRecipientsFile(name, group)
 		if err != nil {
 			errorf("failed to parse recipient file %q: %v", name, err)
 		}
@@ -355,26 +431,24 @@ func encryptNotPass(recs, files []string, identities identityFlags, in io.Reader
 			recipients = append(recipients, id.Recipient())
 		}
 	}
-	encrypt(recipients, in, out, armor)
-}
-
-func encryptPass(in io.Reader, out io.Writer, armor bool) {
-	pass, err := passphrasePromptForEncryption()
-	if err != nil {
-		errorf("%v", err)
-	}
-
-	r, err := age.NewScryptRecipient(pass)
-	if err != nil {
-		errorf("%v", err)
+	if pass {
+		passphrase, err := passphrasePromptForEncryption()
+		if err != nil {
+			errorf("%v", err)
+		}
+		r, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			errorf("%v", err)
+		}
+		testOnlyConfigureScryptIdentity(r)
+		recipients = append(recipients, r)
 	}
-	testOnlyConfigureScryptIdentity(r)
-	encrypt([]age.Recipient{r}, in, out, armor)
+	encryptToRecipients(recipients, in, out, armor)
 }
 
 var testOnlyConfigureScryptIdentity = func(*age.ScryptRecipient) {}
 
-func encrypt(recipients []age.Recipient, in io.Reader, out io.Writer, withArmor bool) {
+func encryptToRecipients(recipients []age.Recipient, in io.Reader, out io.Writer, withArmor bool) {
 	if withArmor {
 		a := armor.NewWriter(out)
 		defer func() {
@@ -402,11 +476,18 @@ func encrypt(recipients []age.Recipient, in io.Reader, out io.Writer, withArmor
 const crlfMangledIntro = "age-encryption.org/v1" + "\r"
 const utf16MangledIntro = "\xff\xfe" + "a\x00g\x00e\x00-\x00e\x00n\x00c\x00r\x00y\x00p\x00"
 
+// rejectScryptIdentity only rejects a header that is exclusively
+// scrypt-encrypted: since age disallows mixing a scrypt stanza with other
+// stanza types for a file encrypted with only -p, any non-scrypt stanza
+// means the header is a combined recipients+passphrase file, and the real
+// identities supplied with -i/-j should get a chance at it first.
 type rejectScryptIdentity struct{}
 
 func (rejectScryptIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
-	if len(stanzas) != 1 || stanzas[0].Type != "scrypt" {
-		return nil, age.ErrIncorrectIdentity
+	for _, s := range stanzas {
+		if s.Type != "scrypt" {
+			return nil, age.ErrIncorrectIdentity
+		}
 	}
 	errorWithHint("file is passphrase-encrypted but identities were specified with -i/--identity or -j",
 		"remove all -i/--identity/-j flags to decrypt passphrase-encrypted files")
@@ -433,6 +514,12 @@ func decryptNotPass(flags identityFlags, in io.Reader, out io.Writer) {
 		}
 	}
 
+	// If none of the supplied identities match, fall back to prompting for
+	// the emergency passphrase: a file encrypted to both -r/-R and -p carries
+	// a scrypt stanza alongside the others, and LazyScryptIdentity only
+	// prompts once it's actually asked to unwrap a scrypt stanza.
+	identities = append(identities, &LazyScryptIdentity{passphrasePromptForDecryption})
+
 	decrypt(identities, in, out)
 }
 
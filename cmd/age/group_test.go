@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveGroupDetectsCycle(t *testing.T) {
+	groups := map[string][]string{
+		"a": {"@b"},
+		"b": {"@a"},
+	}
+	_, err := resolveGroup("groups.txt", "a", groups, make(map[string]bool))
+	if err == nil {
+		t.Fatal("expected a cycle error, got nil")
+	}
+	if !strings.Contains(err.Error(), "cyclically") {
+		t.Fatalf("error %q does not mention the cycle", err)
+	}
+}
+
+func TestResolveGroupSelfReference(t *testing.T) {
+	groups := map[string][]string{
+		"a": {"@a"},
+	}
+	_, err := resolveGroup("groups.txt", "a", groups, make(map[string]bool))
+	if err == nil {
+		t.Fatal("expected a cycle error for a self-referencing group, got nil")
+	}
+}
+
+func TestResolveGroupNestedExpansion(t *testing.T) {
+	const testRecipient = "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+	groups := map[string][]string{
+		"all": {"@ops", testRecipient},
+		"ops": {testRecipient},
+	}
+	r, err := resolveGroup("groups.txt", "all", groups, make(map[string]bool))
+	if err != nil {
+		t.Fatalf("resolveGroup: %v", err)
+	}
+	if r == nil {
+		t.Fatal("resolveGroup returned a nil recipient")
+	}
+}
+
+// TestFlattenGroupDedupesOverlappingMember reproduces "@all = @ops, key"
+// where key also appears inside @ops: it must be resolved (and parsed) only
+// once, deduped by its recipient text rather than by comparing the
+// randomized stanzas it would eventually wrap to.
+func TestFlattenGroupDedupesOverlappingMember(t *testing.T) {
+	const testRecipient = "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+	groups := map[string][]string{
+		"all": {"@ops", testRecipient},
+		"ops": {testRecipient},
+	}
+	members, err := flattenGroup("groups.txt", "all", groups, make(map[string]bool))
+	if err != nil {
+		t.Fatalf("flattenGroup: %v", err)
+	}
+	if len(members) != 1 {
+		t.Fatalf("got %d members, want 1 after dedup", len(members))
+	}
+}
+
+func TestFlattenGroupDedupesDirectDuplicate(t *testing.T) {
+	const testRecipient = "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+	groups := map[string][]string{
+		"ops": {testRecipient, testRecipient},
+	}
+	members, err := flattenGroup("groups.txt", "ops", groups, make(map[string]bool))
+	if err != nil {
+		t.Fatalf("flattenGroup: %v", err)
+	}
+	if len(members) != 1 {
+		t.Fatalf("got %d members, want 1 after dedup", len(members))
+	}
+}
+
+func TestResolveGroupUndefined(t *testing.T) {
+	groups := map[string][]string{"a": {"x"}}
+	if _, err := resolveGroup("groups.txt", "missing", groups, make(map[string]bool)); err == nil {
+		t.Fatal("expected an error for an undefined group, got nil")
+	}
+}
@@ -0,0 +1,510 @@
+// Copyright 2019 The age Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	age "github.com/srest2021/practical-crypto-project"
+	"github.com/srest2021/practical-crypto-project/ageagent"
+	"github.com/srest2021/practical-crypto-project/agefetch"
+	"github.com/srest2021/practical-crypto-project/agegroup"
+	"github.com/srest2021/practical-crypto-project/agessh"
+	"github.com/srest2021/practical-crypto-project/armor"
+	"golang.org/x/crypto/ssh"
+)
+
+// ageIntro is the unarmored intro line of the age format.
+const ageIntro = "age-encryption.org/v1"
+
+// parseRecipient parses a single -r/--recipient argument, or a line of a
+// recipients file, into one or more recipients. "github:user", "gitlab:user"
+// and "sshfp:host" are resolved over the network (or the local cache) by
+// agefetch, which is why these can expand to more than one recipient: a
+// fetched key set may list several keys for the same account.
+func parseRecipient(arg string) ([]age.Recipient, error) {
+	switch {
+	case strings.HasPrefix(arg, "age1"):
+		r, err := age.ParseX25519Recipient(arg)
+		if err != nil {
+			return nil, fmt.Errorf("malformed recipient %q: %v", arg, err)
+		}
+		return []age.Recipient{r}, nil
+	case strings.HasPrefix(arg, "ssh-"):
+		r, err := agessh.ParseRecipient(arg)
+		if err != nil {
+			return nil, fmt.Errorf("malformed recipient %q: %v", arg, err)
+		}
+		return []age.Recipient{r}, nil
+	case strings.HasPrefix(arg, "github:"):
+		return fetchRecipients("github", strings.TrimPrefix(arg, "github:"))
+	case strings.HasPrefix(arg, "gitlab:"):
+		return fetchRecipients("gitlab", strings.TrimPrefix(arg, "gitlab:"))
+	case strings.HasPrefix(arg, "sshfp:"):
+		return fetchRecipients("sshfp", strings.TrimPrefix(arg, "sshfp:"))
+	default:
+		return nil, fmt.Errorf("unknown recipient type: %q", arg)
+	}
+}
+
+func fetchRecipients(kind, target string) ([]age.Recipient, error) {
+	recipients, err := agefetch.Resolve(kind, target, agefetchOptions())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s:%s: %v", kind, target, err)
+	}
+	return recipients, nil
+}
+
+// parseRecipientsFile parses name as a recipients file: one recipient per
+// line, with "#" comments and blank lines ignored, same as always. It also
+// recognizes group definitions, "@name = member, member, ...", where each
+// member is itself a recipient or another "@name" reference (resolved
+// recursively, with cycle detection). group selects which definition to
+// expand when the file defines more than one; it's ignored if the file
+// defines none.
+func parseRecipientsFile(name, group string) ([]age.Recipient, error) {
+	var in io.Reader
+	if name == "-" {
+		if stdinInUse {
+			return nil, fmt.Errorf("standard input is used for multiple purposes")
+		}
+		stdinInUse = true
+		in = os.Stdin
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	groups := make(map[string][]string)
+	var recipients []age.Recipient
+	scanner := bufio.NewScanner(in)
+	var n int
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "@") {
+			gname, members, err := parseGroupDefinition(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s at line %d: %v", name, n, err)
+			}
+			groups[gname] = members
+			continue
+		}
+		rs, err := parseRecipient(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s at line %d: %v", name, n, err)
+		}
+		recipients = append(recipients, rs...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(groups) == 0:
+		// group may be set for a different -R file on the same command
+		// line; this file simply doesn't define any groups for it to
+		// select, so there's nothing to do beyond the plain recipients
+		// already collected above.
+	case len(groups) == 1 && group == "":
+		for g := range groups {
+			group = g
+		}
+		fallthrough
+	case len(groups) > 0:
+		if group == "" {
+			var names []string
+			for g := range groups {
+				names = append(names, g)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("%q defines multiple groups (%s); select one with -g/--group", name, strings.Join(names, ", "))
+		}
+		r, err := resolveGroup(name, group, groups, make(map[string]bool))
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("%q has no recipients", name)
+	}
+	return recipients, nil
+}
+
+// parseGroupDefinition parses a "@name = member, member, ..." line.
+func parseGroupDefinition(line string) (name string, members []string, err error) {
+	eq := strings.Index(line, "=")
+	if eq < 0 {
+		return "", nil, fmt.Errorf("malformed group definition %q", line)
+	}
+	name = strings.TrimSpace(strings.TrimPrefix(line[:eq], "@"))
+	if name == "" {
+		return "", nil, fmt.Errorf("group definition %q is missing a name", line)
+	}
+	for _, m := range strings.Split(line[eq+1:], ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			members = append(members, m)
+		}
+	}
+	if len(members) == 0 {
+		return "", nil, fmt.Errorf("group %q has no members", name)
+	}
+	return name, members, nil
+}
+
+// resolveGroup expands the group named name into an *agegroup.GroupRecipient.
+// It's a thin wrapper around flattenGroup, which does the actual recursive
+// expansion and dedup.
+func resolveGroup(file, name string, groups map[string][]string, visiting map[string]bool) (age.Recipient, error) {
+	members, err := flattenGroup(file, name, groups, visiting)
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]age.Recipient, len(members))
+	for i, m := range members {
+		recipients[i] = m.recipient
+	}
+	return agegroup.New(name, recipients...), nil
+}
+
+// groupMember pairs a resolved recipient with the recipient-file text it
+// came from, which flattenGroup uses as the member's dedup key.
+type groupMember struct {
+	key       string
+	recipient age.Recipient
+}
+
+// flattenGroup expands the group named name, recursively resolving any
+// "@other" member references against groups, into the flat, deduplicated
+// set of recipients it resolves to. visiting tracks the groups currently
+// being expanded, so a reference cycle is reported instead of recursing
+// forever.
+//
+// Dedup is keyed on each member's original recipient-file text (e.g. the
+// age1.../ssh-.../github:user line, also for members reached through an
+// "@group" reference), not on the stanzas a recipient produces: a real recipient's
+// Wrap generates a fresh ephemeral key and ciphertext on every call, so two
+// Wrap calls for the same public key never produce identical output — that
+// can't be used to recognize "the same key listed in two overlapping
+// groups" after the fact.
+func flattenGroup(file, name string, groups map[string][]string, visiting map[string]bool) ([]groupMember, error) {
+	if visiting[name] {
+		return nil, fmt.Errorf("%s: group %q is defined cyclically", file, name)
+	}
+	members, ok := groups[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: undefined group %q", file, name)
+	}
+	visiting[name] = true
+	defer delete(visiting, name)
+
+	seen := make(map[string]bool)
+	var flat []groupMember
+	for _, m := range members {
+		if strings.HasPrefix(m, "@") {
+			sub, err := flattenGroup(file, strings.TrimPrefix(m, "@"), groups, visiting)
+			if err != nil {
+				return nil, err
+			}
+			// A key can repeat within sub when one member (e.g. github:user)
+			// expanded to several recipients; only keys seen before this
+			// sub-group are duplicates.
+			added := make(map[string]bool)
+			for _, e := range sub {
+				if seen[e.key] && !added[e.key] {
+					continue
+				}
+				added[e.key] = true
+				flat = append(flat, e)
+			}
+			for k := range added {
+				seen[k] = true
+			}
+			continue
+		}
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		rs, err := parseRecipient(m)
+		if err != nil {
+			return nil, fmt.Errorf("%s: group %q: %v", file, name, err)
+		}
+		for _, r := range rs {
+			flat = append(flat, groupMember{key: m, recipient: r})
+		}
+	}
+	return flat, nil
+}
+
+// parseIdentitiesFile parses name as a list of identities, either one age
+// secret key per line, with "#" comments and blank lines ignored, or a
+// single SSH private key. If name instead holds an age-encrypted file (detected from its
+// armored or binary intro) it's treated as a passphrase-protected identity
+// file: the returned value is a single *EncryptedIdentity that prompts for
+// the passphrase (or reads AGE_IDENTITY_PASSPHRASE) and decrypts the file
+// in memory the first time it's used, either as an age.Identity or via
+// identitiesToRecipients for "age -e -i".
+func parseIdentitiesFile(name string) ([]age.Identity, error) {
+	const identityFileSizeLimit = 1 << 24 // 16 MiB
+
+	var mtime int64
+	var contents []byte
+	if name == "-" {
+		if stdinInUse {
+			return nil, fmt.Errorf("standard input is used for multiple purposes")
+		}
+		stdinInUse = true
+		data, err := io.ReadAll(io.LimitReader(os.Stdin, identityFileSizeLimit))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read standard input: %v", err)
+		}
+		contents = data
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		if fi, err := f.Stat(); err == nil {
+			mtime = fi.ModTime().UnixNano()
+		}
+		data, err := io.ReadAll(io.LimitReader(f, identityFileSizeLimit))
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %v", name, err)
+		}
+		contents = data
+	}
+
+	// The whole file is read into memory above, rather than handed to
+	// newEncryptedIdentity as a lazily-read bufio.Reader over an open *os.File,
+	// because EncryptedIdentity.decrypt doesn't run until the identity is
+	// actually used — by which point a deferred f.Close() here would long
+	// since have closed the file out from under it.
+	if bytes.HasPrefix(contents, []byte(armor.Header)) {
+		return []age.Identity{newEncryptedIdentity(name, mtime, armor.NewReader(bytes.NewReader(contents)))}, nil
+	}
+	if bytes.HasPrefix(contents, []byte(ageIntro)) {
+		return []age.Identity{newEncryptedIdentity(name, mtime, bytes.NewReader(contents))}, nil
+	}
+
+	return parseIdentitiesReader(name, bytes.NewReader(contents))
+}
+
+// parseIdentitiesReader parses the plaintext contents of an identity file:
+// either a single SSH private key, or one or more age secret keys.
+func parseIdentitiesReader(name string, f io.Reader) ([]age.Identity, error) {
+	const identitySizeLimit = 1 << 24 // 16 MiB
+	contents, err := io.ReadAll(io.LimitReader(f, identitySizeLimit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %v", name, err)
+	}
+
+	if bytes.HasPrefix(contents, []byte("-----BEGIN")) {
+		id, err := agessh.ParseIdentity(contents)
+		if sshErr, ok := err.(*ssh.PassphraseMissingError); ok {
+			pass, err := readSecret(fmt.Sprintf("Enter passphrase for %q:", name))
+			if err != nil {
+				return nil, fmt.Errorf("could not read passphrase: %v", err)
+			}
+			id, err = agessh.ParseEncryptedSSHIdentity(sshErr.PublicKey, contents, pass)
+			if err != nil {
+				return nil, err
+			}
+			return []age.Identity{id}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed SSH identity in %q: %v", name, err)
+		}
+		return []age.Identity{id}, nil
+	}
+
+	var ids []age.Identity
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	var n int
+	for scanner.Scan() {
+		n++
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, err := age.ParseX25519Identity(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s at line %d: %v", name, n, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%q has no identities", name)
+	}
+	return ids, nil
+}
+
+// LazyScryptIdentity is an age.Identity that only prompts for a passphrase,
+// via Passphrase, once it's actually asked to unwrap a scrypt stanza. This
+// lets it be included alongside other identities without prompting unless
+// it's needed: as the sole identity when decrypting without -i, and as a
+// fallback appended after real -i/-j identities when a file was encrypted
+// to a mix of recipients and an emergency passphrase.
+type LazyScryptIdentity struct {
+	Passphrase func() (string, error)
+}
+
+func (i *LazyScryptIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	var scrypt *age.Stanza
+	for _, s := range stanzas {
+		if s.Type == "scrypt" {
+			scrypt = s
+			break
+		}
+	}
+	if scrypt == nil {
+		return nil, age.ErrIncorrectIdentity
+	}
+
+	var fp [32]byte
+	if len(scrypt.Args) > 0 {
+		fp = ageagent.FingerprintScryptSalt([]byte(scrypt.Args[0]))
+	}
+	agent := ageAgentClient()
+
+	pass, cached := "", false
+	if agent != nil {
+		pass, cached = agent.GetPassphrase(fp)
+	}
+	if !cached {
+		p, err := i.Passphrase()
+		if err != nil {
+			return nil, fmt.Errorf("could not read passphrase: %v", err)
+		}
+		pass = p
+		if agent != nil {
+			agent.PutPassphrase(fp, pass, 0)
+		}
+	}
+
+	id, err := age.NewScryptIdentity(pass)
+	if err != nil {
+		return nil, err
+	}
+	return id.Unwrap([]*age.Stanza{scrypt})
+}
+
+// EncryptedIdentity wraps an identity file that is itself an age-encrypted
+// file, as produced by "age -p -o key.txt.age key.txt". The passphrase is
+// requested lazily, at most once, and the decrypted identities are cached
+// so that using the file both to decrypt (-d -i) and as a recipient source
+// (-e -i) only prompts a single time.
+type EncryptedIdentity struct {
+	name       string
+	fp         [32]byte
+	contents   io.Reader
+	passphrase func() (string, error)
+
+	identities []age.Identity
+}
+
+func newEncryptedIdentity(name string, mtime int64, contents io.Reader) *EncryptedIdentity {
+	return &EncryptedIdentity{
+		name: name,
+		fp:   ageagent.FingerprintIdentityFile(name, mtime),
+		passphrase: func() (string, error) {
+			if pass := os.Getenv("AGE_IDENTITY_PASSPHRASE"); pass != "" {
+				return pass, nil
+			}
+			pass, err := readSecret(fmt.Sprintf("Enter passphrase for identity file %q:", name))
+			if err != nil {
+				return "", fmt.Errorf("could not read passphrase: %v", err)
+			}
+			return string(pass), nil
+		},
+		contents: contents,
+	}
+}
+
+// decrypt returns the identity file's decrypted identities, prompting for
+// its passphrase at most once per process: first against a running
+// age-agent (keyed by the file's path and mtime), falling back to
+// i.passphrase on a miss and feeding the result back to the agent.
+func (i *EncryptedIdentity) decrypt() ([]age.Identity, error) {
+	if i.identities != nil {
+		return i.identities, nil
+	}
+
+	agent := ageAgentClient()
+	if agent != nil {
+		if plaintext, ok := agent.GetIdentity(i.fp); ok {
+			ids, err := parseIdentitiesReader(i.name, bytes.NewReader(plaintext))
+			if err == nil {
+				i.identities = ids
+				return ids, nil
+			}
+		}
+	}
+
+	var plaintext bytes.Buffer
+	r, err := age.Decrypt(i.contents, &LazyScryptIdentity{i.passphrase})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt identity file %q: %v", i.name, err)
+	}
+	if _, err := io.Copy(&plaintext, r); err != nil {
+		return nil, fmt.Errorf("failed to decrypt identity file %q: %v", i.name, err)
+	}
+	ids, err := parseIdentitiesReader(i.name, bytes.NewReader(plaintext.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	i.identities = ids
+	if agent != nil {
+		agent.PutIdentity(i.fp, plaintext.Bytes(), 0)
+	}
+	return ids, nil
+}
+
+// Unwrap implements age.Identity, so an *EncryptedIdentity can be used
+// directly wherever a decrypted identity file's identities would be.
+func (i *EncryptedIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	ids, err := i.decrypt()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		fileKey, err := id.Unwrap(stanzas)
+		if errors.Is(err, age.ErrIncorrectIdentity) {
+			continue
+		}
+		return fileKey, err
+	}
+	return nil, age.ErrIncorrectIdentity
+}
+
+// Recipients decrypts the identity file and converts its identities to the
+// matching recipients, for "age -e -i encrypted-identities.age".
+func (i *EncryptedIdentity) Recipients() ([]age.Recipient, error) {
+	ids, err := i.decrypt()
+	if err != nil {
+		return nil, err
+	}
+	return identitiesToRecipients(ids)
+}